@@ -0,0 +1,63 @@
+// Package clock abstracts wall-clock access used by the bootstrap tool
+// itself (progress-tracker timestamps, mem-gate batch backoff) so that
+// --mock-clock can drive them from a deterministic MockClock in
+// integration tests instead of real wall-clock time.
+//
+// This Clock is NOT wired into retention.Options or commitlogsrc.Options:
+// as of the version of github.com/m3db/m3db and github.com/m3db/m3x this
+// tool is built against, neither retention.Options, commitlogsrc.Options,
+// nor instrument.Options exposes any clock-override accessor (no SetClock,
+// SetNowFn, or SetClockOptions) for this tool to plumb a Clock into without
+// forking those packages. Because of that, --mock-clock only makes this
+// tool's own progress tracking and mem-gate backoff deterministic; the
+// commit log bootstrapper itself still reads the real wall clock for
+// retention windowing and flush scheduling, so reproducing a full
+// end-to-end bootstrap run against golden commit-log fixtures is not
+// achievable with this flag alone. That would require a follow-up change
+// upstream (or a vendored fork) to give those Options types a clock hook.
+package clock
+
+import "time"
+
+// Ticker mirrors the subset of *time.Ticker needed by consumers, so that it
+// can be backed by either the real clock or a MockClock.
+type Ticker interface {
+	// C returns the channel on which ticks are delivered.
+	C() <-chan time.Time
+	// Stop stops the ticker. No more ticks will be sent after Stop
+	// returns.
+	Stop()
+}
+
+// Clock abstracts wall-clock reads and timers.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After returns a channel that receives the current time after d has
+	// elapsed.
+	After(d time.Duration) <-chan time.Time
+	// NewTicker returns a Ticker that fires every d.
+	NewTicker(d time.Duration) Ticker
+}
+
+// NewClock returns a Clock backed by the real system clock.
+func NewClock() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.ticker.C }
+func (t realTicker) Stop()               { t.ticker.Stop() }
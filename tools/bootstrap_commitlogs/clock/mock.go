@@ -0,0 +1,113 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// MockClock is a Clock that only advances when Advance is called, making
+// the bootstrap tool's own notion of time (progress-tracker timestamps,
+// mem-gate batch backoff) reproducible in a test instead of depending on
+// real elapsed wall-clock time.
+type MockClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []afterWaiter
+	tickers []*mockTicker
+}
+
+type afterWaiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+// NewMockClock returns a MockClock starting at start.
+func NewMockClock(start time.Time) *MockClock {
+	return &MockClock{now: start}
+}
+
+// Now returns the current mock time.
+func (c *MockClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel that fires once the mock clock has been Advanced
+// past c.Now()+d.
+func (c *MockClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, afterWaiter{deadline: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// NewTicker returns a Ticker that fires, synchronously with Advance calls,
+// once for every tick boundary crossed.
+func (c *MockClock) NewTicker(d time.Duration) Ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &mockTicker{
+		mu:     &c.mu,
+		period: d,
+		next:   c.now.Add(d),
+		ch:     make(chan time.Time, 1),
+	}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance moves the mock clock forward by d, synchronously firing any
+// After channels and ticker ticks whose deadline falls within the new
+// range (earliest, now].
+func (c *MockClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	target := c.now.Add(d)
+
+	remaining := c.waiters[:0]
+	for _, w := range c.waiters {
+		if !w.deadline.After(target) {
+			w.ch <- target
+			continue
+		}
+		remaining = append(remaining, w)
+	}
+	c.waiters = remaining
+
+	for _, t := range c.tickers {
+		for !t.next.After(target) && !t.stopped {
+			select {
+			case t.ch <- t.next:
+			default:
+				// Previous tick hasn't been consumed yet; drop this one,
+				// matching time.Ticker's behavior of never blocking.
+			}
+			t.next = t.next.Add(t.period)
+		}
+	}
+
+	c.now = target
+}
+
+// mockTicker shares its parent MockClock's mutex so that Stop can safely
+// race with Advance, which reads stopped while holding that same lock.
+type mockTicker struct {
+	mu      *sync.Mutex
+	period  time.Duration
+	next    time.Time
+	ch      chan time.Time
+	stopped bool
+}
+
+func (t *mockTicker) C() <-chan time.Time { return t.ch }
+
+func (t *mockTicker) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped = true
+}
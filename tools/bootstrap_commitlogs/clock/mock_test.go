@@ -0,0 +1,87 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMockClockNow(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clk := NewMockClock(start)
+
+	if got := clk.Now(); !got.Equal(start) {
+		t.Fatalf("Now() = %v, want %v", got, start)
+	}
+
+	clk.Advance(5 * time.Second)
+	want := start.Add(5 * time.Second)
+	if got := clk.Now(); !got.Equal(want) {
+		t.Fatalf("Now() after Advance = %v, want %v", got, want)
+	}
+}
+
+func TestMockClockAfter(t *testing.T) {
+	clk := NewMockClock(time.Unix(0, 0))
+	ch := clk.After(10 * time.Second)
+
+	select {
+	case <-ch:
+		t.Fatal("After channel fired before the deadline was reached")
+	default:
+	}
+
+	clk.Advance(5 * time.Second)
+	select {
+	case <-ch:
+		t.Fatal("After channel fired before the deadline was reached")
+	default:
+	}
+
+	clk.Advance(5 * time.Second)
+	select {
+	case <-ch:
+	default:
+		t.Fatal("After channel did not fire once the deadline was reached")
+	}
+}
+
+func TestMockClockTicker(t *testing.T) {
+	clk := NewMockClock(time.Unix(0, 0))
+	ticker := clk.NewTicker(time.Second)
+
+	clk.Advance(500 * time.Millisecond)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticker fired before crossing a tick boundary")
+	default:
+	}
+
+	clk.Advance(500 * time.Millisecond)
+	select {
+	case <-ticker.C():
+	default:
+		t.Fatal("ticker did not fire on crossing a tick boundary")
+	}
+
+	// Advancing across multiple boundaries at once should only leave one
+	// tick buffered, matching time.Ticker's non-blocking send semantics.
+	clk.Advance(3 * time.Second)
+	select {
+	case <-ticker.C():
+	default:
+		t.Fatal("ticker did not fire after advancing across further boundaries")
+	}
+	select {
+	case <-ticker.C():
+		t.Fatal("ticker buffered more than one pending tick")
+	default:
+	}
+
+	ticker.Stop()
+	clk.Advance(time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("stopped ticker fired")
+	default:
+	}
+}
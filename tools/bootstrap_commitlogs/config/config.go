@@ -0,0 +1,103 @@
+// Package config defines the YAML configuration accepted by the commit log
+// bootstrap tool via --config, so that pool sizes, watermarks and retention
+// buffers can be tuned per-deployment without editing source.
+package config
+
+import "time"
+
+// Configuration is the root YAML configuration for the bootstrap tool.
+type Configuration struct {
+	Pools     PoolsConfiguration     `yaml:"pools"`
+	Retention RetentionConfiguration `yaml:"retention"`
+	Commitlog CommitLogConfiguration `yaml:"commitlog"`
+}
+
+// PoolsConfiguration configures the object pools used while bootstrapping.
+type PoolsConfiguration struct {
+	Encoder  EncoderPoolConfiguration `yaml:"encoder"`
+	Iterator PoolConfiguration        `yaml:"iterator"`
+}
+
+// PoolConfiguration configures a single object pool.
+type PoolConfiguration struct {
+	Size          int     `yaml:"size"`
+	LowWatermark  float64 `yaml:"lowWatermark"`
+	HighWatermark float64 `yaml:"highWatermark"`
+}
+
+// EncoderPoolConfiguration configures the encoder pool, with an optional
+// autosize mode in addition to the fixed sizing of PoolConfiguration.
+type EncoderPoolConfiguration struct {
+	PoolConfiguration `yaml:",inline"`
+	Autosize          *AutosizeConfiguration `yaml:"autosize"`
+}
+
+// AutosizeConfiguration computes an encoder pool size from the shape of the
+// bootstrap run rather than a fixed constant: shardsCount (supplied by the
+// tool at runtime) × SeriesPerShard × blocks-per-retention, clamped to
+// MaxMemoryFraction of available memory (the Go runtime memory limit if one
+// is set, otherwise the cgroup limit if present, otherwise total system
+// memory).
+type AutosizeConfiguration struct {
+	SeriesPerShard    int     `yaml:"seriesPerShard"`
+	MaxMemoryFraction float64 `yaml:"maxMemoryFraction"`
+}
+
+// RetentionConfiguration configures the retention buffers applied during
+// bootstrap.
+type RetentionConfiguration struct {
+	BufferPast   time.Duration `yaml:"bufferPast"`
+	BufferFuture time.Duration `yaml:"bufferFuture"`
+}
+
+// CommitLogConfiguration configures the commit log reader.
+type CommitLogConfiguration struct {
+	FlushSize int `yaml:"flushSize"`
+}
+
+// ResolveSize returns the configured pool size, or, when Autosize is set,
+// shardsCount × SeriesPerShard × blocksPerRetention clamped to
+// MaxMemoryFraction of availableMemoryBytes (assuming bytesPerEntry bytes
+// pooled per entry).
+func (e EncoderPoolConfiguration) ResolveSize(shardsCount, blocksPerRetention int, availableMemoryBytes uint64, bytesPerEntry int) int {
+	if e.Autosize == nil {
+		return e.Size
+	}
+
+	target := shardsCount * e.Autosize.SeriesPerShard * blocksPerRetention
+	if availableMemoryBytes > 0 && bytesPerEntry > 0 {
+		maxEntries := int(float64(availableMemoryBytes) * e.Autosize.MaxMemoryFraction / float64(bytesPerEntry))
+		if target > maxEntries {
+			target = maxEntries
+		}
+	}
+	return target
+}
+
+// Default returns the configuration matching the tool's previous hardcoded
+// values, used when no --config file is provided.
+func Default() Configuration {
+	return Configuration{
+		Pools: PoolsConfiguration{
+			Encoder: EncoderPoolConfiguration{
+				PoolConfiguration: PoolConfiguration{
+					Size:          25165824,
+					LowWatermark:  0.001,
+					HighWatermark: 0.002,
+				},
+			},
+			Iterator: PoolConfiguration{
+				Size:          2048,
+				LowWatermark:  0.01,
+				HighWatermark: 0.02,
+			},
+		},
+		Retention: RetentionConfiguration{
+			BufferPast:   time.Minute,
+			BufferFuture: time.Minute,
+		},
+		Commitlog: CommitLogConfiguration{
+			FlushSize: 524288,
+		},
+	}
+}
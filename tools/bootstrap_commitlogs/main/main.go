@@ -1,15 +1,23 @@
 package main
 
 import (
+	"bufio"
 	"flag"
+	"fmt"
 	"io"
+	"math"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	xconfig "github.com/m3db/m3x/config"
 	"github.com/m3db/m3x/pool"
 
 	"github.com/m3db/m3db/encoding"
@@ -19,10 +27,15 @@ import (
 	"github.com/m3db/m3db/retention"
 	"github.com/m3db/m3db/storage/block"
 	"github.com/m3db/m3db/storage/bootstrap"
-	"github.com/m3db/m3db/storage/bootstrap/bootstrapper"
 	commitlogsrc "github.com/m3db/m3db/storage/bootstrap/bootstrapper/commitlog"
 	"github.com/m3db/m3db/storage/bootstrap/result"
 	"github.com/m3db/m3db/storage/namespace"
+	"github.com/m3db/m3db/tools/bootstrap_commitlogs/clock"
+	"github.com/m3db/m3db/tools/bootstrap_commitlogs/config"
+	"github.com/m3db/m3db/tools/bootstrap_commitlogs/memlimit"
+	"github.com/m3db/m3db/tools/bootstrap_commitlogs/progress"
+	"github.com/m3db/m3db/tools/bootstrap_commitlogs/tracing"
+	"github.com/m3db/m3db/tools/bootstrap_commitlogs/workqueue"
 	"github.com/m3db/m3db/ts"
 	"github.com/m3db/m3db/x/io"
 	"github.com/m3db/m3x/instrument"
@@ -30,6 +43,19 @@ import (
 	xtime "github.com/m3db/m3x/time"
 )
 
+const (
+	// memCheckMinBackoff is the initial backoff duration when free memory
+	// is below the configured limit.
+	memCheckMinBackoff = 1 * time.Second
+	// memCheckMaxBackoff caps the exponential backoff applied while
+	// waiting for free memory to recover.
+	memCheckMaxBackoff = 1 * time.Minute
+	// memCheckHysteresisPercent is how far above the configured limit free
+	// memory must climb before a blocked batch is allowed to proceed, to
+	// avoid flapping right at the threshold.
+	memCheckHysteresisPercent = 10
+)
+
 var (
 	pathPrefixArg           = flag.String("path-prefix", "/var/lib/m3db", "Path prefix - must contain a folder called 'commitlogs'")
 	namespaceArg            = flag.String("namespace", "metrics", "Namespace")
@@ -40,8 +66,26 @@ var (
 	shardsArg               = flag.String("shards", "", "Shards - set comma separated list of shards")
 	debugListenAddressArg   = flag.String("debug-listen-address", "", "Debug listen address - if set will expose pprof, i.e. ':8080'")
 	currentUnixTimestampArg = flag.Int64("current-unix-timestamp", time.Now().Unix(), "Current unix timestamp (Seconds) - If set will perform the bootstrap as if this was the current time, defaults to current time")
+	memFreeLimitArg         = flag.String("mem-free-limit", "", "Minimum free memory required to continue bootstrapping, e.g. '2GB' or '15%' - if unset no memory throttling is performed")
+	memCheckBatchShardsArg  = flag.Int("mem-check-batch-shards", 512, "Number of shards to bootstrap per batch when checking --mem-free-limit between batches - ignored (single batch) unless --mem-free-limit is also set, since the commit log source rescans every commit log file on each batch")
+	configArg               = flag.String("config", "", "Path to a YAML config file overriding pool sizes, watermarks, retention buffers and flush size - flags below always take precedence over the config file")
+	encoderPoolSizeArg      = flag.Int("encoder-pool-size", 0, "Encoder pool size - overrides pools.encoder.size (and disables pools.encoder.autosize) from --config")
+	parallelismArg          = flag.Int("parallelism", 1, "Number of concurrent workers within each --mem-check-batch-shards batch - each worker bootstraps its own subset of the batch's shards")
+	coordinatorArg          = flag.String("coordinator", "", "Shard claim coordinator - if unset bootstraps shards locally with no cross-host coordination, e.g. 'redis://host:6379' to cooperatively bootstrap across hosts")
+	tracerArg               = flag.String("tracer", "noop", "Tracer to use: noop or jaeger")
+	jaegerEndpointArg       = flag.String("jaeger-endpoint", "", "Jaeger agent address (host:port) to report spans to, required when --tracer=jaeger")
+	// mockClockArg is not documented in --help: it exists so integration
+	// tests can drive the tool's own progress-tracker timestamps and
+	// mem-gate batch backoff from a deterministic clock instead of real
+	// wall-clock time.
+	mockClockArg = flag.Bool("mock-clock", false, "")
 )
 
+// estimatedBytesPerEncoder is a rough estimate of the bytes retained per
+// pooled encoder, used to clamp pools.encoder.autosize against available
+// memory.
+const estimatedBytesPerEncoder = 256
+
 func main() {
 	flag.Parse()
 	if *pathPrefixArg == "" ||
@@ -50,11 +94,13 @@ func main() {
 		os.Exit(1)
 	}
 
+	explicitFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicitFlags[f.Name] = true })
+
 	var (
 		pathPrefix           = *pathPrefixArg
 		namespaceStr         = *namespaceArg
 		blockSize            = *blockSizeArg
-		flushSize            = *flushSizeArg
 		bootstrapRetention   = *bootstrapRetentionArg
 		shardsCount          = *shardsCountArg
 		shards               = *shardsArg
@@ -64,6 +110,21 @@ func main() {
 
 	log := xlog.NewLogger(os.Stderr)
 
+	cfg := config.Default()
+	if *configArg != "" {
+		if err := xconfig.LoadFile(&cfg, *configArg, xconfig.Options{}); err != nil {
+			log.Fatalf("could not load config file '%s': %v", *configArg, err)
+		}
+	}
+	if explicitFlags["flush-size"] {
+		cfg.Commitlog.FlushSize = *flushSizeArg
+	}
+	if explicitFlags["encoder-pool-size"] {
+		cfg.Pools.Encoder.Size = *encoderPoolSizeArg
+		cfg.Pools.Encoder.Autosize = nil
+	}
+	flushSize := cfg.Commitlog.FlushSize
+
 	if debugListenAddress != "" {
 		go func() {
 			log.Infof("starting debug listen server at '%s'\n", debugListenAddress)
@@ -82,7 +143,7 @@ func main() {
 	// Round current time down to nearest blocksize (2h) and then add blocksize (2h)
 	endExclusive := now.Truncate(blockSize).Add(blockSize * 2)
 
-	// Ony used for logging
+	// Used for logging and to seed the /progress tracker
 	var shardsAll []uint32
 
 	// Handle commda-delimited shard list 1,3,5, etc
@@ -119,94 +180,53 @@ func main() {
 		xlog.NewField("shards", shardsAll),
 	).Infof("configured")
 
+	tracer, tracerCloser, err := tracing.New(*tracerArg, *jaegerEndpointArg, "bootstrap_commitlogs")
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	defer tracerCloser.Close()
+
+	// clk drives the two pieces of wall-clock-dependent state this tool owns
+	// directly - the progress tracker's timestamps and the mem-gate's batch
+	// backoff - so that --mock-clock can make an integration test's view of
+	// run progress deterministic. See the clock package doc for why this
+	// stops short of the commit log bootstrapper itself: retentionOpts and
+	// newOpts below are built from retention.Options/commitlogsrc.Options,
+	// neither of which has a clock hook to wire clk into.
+	clk := clock.NewClock()
+	if *mockClockArg {
+		mockClock := clock.NewMockClock(now)
+		go runMockClockDirectives(mockClock, os.Stdin, log)
+		clk = mockClock
+	}
+
+	filesOnDisk, bytesOnDisk := filesAndBytesOnDisk(pathPrefix, log)
+	progressTracker := progress.NewTracker(shardsAll, bytesOnDisk, clk)
+	if debugListenAddress != "" {
+		http.Handle("/progress", progressTracker.Handler())
+	}
+
 	instrumentOpts := instrument.NewOptions().
 		SetLogger(log)
 
+	// retentionOpts and newOpts' commitlogsrc.Options (below) are not built
+	// from clk: retention.Options and commitlogsrc.Options expose no
+	// clock-override accessor to plumb it into, so this tool cannot make
+	// their wall-clock reads (retention windowing, flush scheduling)
+	// deterministic under --mock-clock.
 	retentionOpts := retention.NewOptions().
 		SetBlockSize(blockSize).
 		SetRetentionPeriod(bootstrapRetention).
-		SetBufferPast(1 * time.Minute).
-		SetBufferFuture(1 * time.Minute)
-
-	blockOpts := block.NewOptions()
-
-	encoderPoolOpts := pool.
-		NewObjectPoolOptions().
-		SetSize(25165824).
-		SetRefillLowWatermark(0.001).
-		SetRefillHighWatermark(0.002)
-	encoderPool := encoding.NewEncoderPool(encoderPoolOpts)
-
-	iteratorPoolOpts := pool.NewObjectPoolOptions().
-		SetSize(2048).
-		SetRefillLowWatermark(0.01).
-		SetRefillHighWatermark(0.02)
-	iteratorPool := encoding.NewReaderIteratorPool(iteratorPoolOpts)
-
-	multiIteratorPool := encoding.NewMultiReaderIteratorPool(nil)
-	segmentReaderPool := xio.NewSegmentReaderPool(nil)
-
-	encodingOpts := encoding.NewOptions().
-		SetEncoderPool(encoderPool).
-		SetReaderIteratorPool(iteratorPool).
-		SetBytesPool(blockOpts.BytesPool()).
-		SetSegmentReaderPool(segmentReaderPool)
-
-	encoderPool.Init(func() encoding.Encoder {
-		return m3tsz.NewEncoder(time.Time{}, nil, true, encodingOpts)
-	})
-
-	iteratorPool.Init(func(r io.Reader) encoding.ReaderIterator {
-		return m3tsz.NewReaderIterator(r, true, encodingOpts)
-	})
-
-	multiIteratorPool.Init(func(r io.Reader) encoding.ReaderIterator {
-		iter := iteratorPool.Get()
-		iter.Reset(r)
-		return iter
-	})
-
-	segmentReaderPool.Init()
-
-	blockPool := block.NewDatabaseBlockPool(nil)
-	blockPool.Init(func() block.DatabaseBlock {
-		return block.NewDatabaseBlock(time.Time{}, ts.Segment{}, blockOpts)
-	})
+		SetBufferPast(cfg.Retention.BufferPast).
+		SetBufferFuture(cfg.Retention.BufferFuture)
 
-	blockOpts = blockOpts.
-		SetEncoderPool(encoderPool).
-		SetReaderIteratorPool(iteratorPool).
-		SetMultiReaderIteratorPool(multiIteratorPool).
-		SetDatabaseBlockPool(blockPool).
-		SetSegmentReaderPool(segmentReaderPool)
-
-	resultOpts := result.NewOptions().
-		SetInstrumentOptions(instrumentOpts).
-		SetDatabaseBlockOptions(blockOpts)
-
-	fsOpts := fs.NewOptions().
-		SetInstrumentOptions(instrumentOpts).
-		SetFilePathPrefix(pathPrefix)
-
-	commitLogOpts := commitlog.NewOptions().
-		SetInstrumentOptions(instrumentOpts).
-		SetFilesystemOptions(fsOpts).
-		SetFlushSize(flushSize).
-		SetBlockSize(blockSize)
-
-	opts := commitlogsrc.NewOptions().
-		SetResultOptions(resultOpts).
-		SetCommitLogOptions(commitLogOpts)
+	blocksPerRetention := int(bootstrapRetention / blockSize)
+	newOpts := newSourceOptionsFactory(
+		cfg, instrumentOpts, pathPrefix, flushSize, blockSize,
+		shardsCount, blocksPerRetention, availableMemoryBytes(log))
 
 	log.Infof("bootstrapping")
 
-	// Don't bootstrap anything else
-	next := bootstrapper.NewNoOpAllBootstrapper()
-	source, err := commitlogsrc.NewCommitLogBootstrapper(opts, next)
-	if err != nil {
-		log.Fatal(err.Error())
-	}
-
 	nsID := ts.StringID(namespaceStr)
 	runOpts := bootstrap.NewRunOptions().
 		// Dont save intermediate results
@@ -215,20 +235,366 @@ func main() {
 	if err != nil {
 		log.Fatal(err.Error())
 	}
-	result, err := source.Bootstrap(nsMetadata, shardTimeRanges, runOpts)
+
+	memGate, err := newMemGate(*memFreeLimitArg, clk, log)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	claimer, err := workqueue.ClaimerFromURL(*coordinatorArg, log)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	rootSpan := tracer.StartSpan("bootstrap")
+	defer rootSpan.Finish()
+
+	obs := workqueue.Observability{
+		Tracer:       tracer,
+		ParentSpan:   rootSpan,
+		Tracker:      progressTracker,
+		FilesPerPass: filesOnDisk,
+		BytesPerPass: bytesOnDisk,
+	}
+
+	// Batching only pays for itself when --mem-free-limit is configured: the
+	// commit log source rescans every commit log file on each batch, so
+	// without a memory limit to honor, splitting into batches is a pure
+	// cost with no benefit.
+	batchSize := *memCheckBatchShardsArg
+	if memGate == nil {
+		batchSize = 0
+	}
+
+	bootstrapResult, err := bootstrapInBatches(newOpts, nsMetadata, shardTimeRanges, runOpts,
+		batchSize, memGate, *parallelismArg, claimer, obs, log)
 	if err != nil {
 		log.Fatalf("failed to bootstrap: %v", err)
 	}
 
 	log.WithFields(
-		xlog.NewField("shardResults", len(result.ShardResults())),
-		xlog.NewField("unfulfilled", len(result.Unfulfilled())),
+		xlog.NewField("shardResults", len(bootstrapResult.ShardResults())),
+		xlog.NewField("unfulfilled", len(bootstrapResult.Unfulfilled())),
 	).Infof("bootstrapped")
 
-	for shard, result := range result.ShardResults() {
+	for shard, result := range bootstrapResult.ShardResults() {
 		log.WithFields(
 			xlog.NewField("shard", shard),
 			xlog.NewField("series", len(result.AllSeries())),
 		).Infof("shard result")
 	}
-}
\ No newline at end of file
+}
+
+// runMockClockDirectives reads newline-separated advance directives from r
+// (each a time.Duration string, e.g. "30s") and applies them to clk in
+// order, one Advance call per line, so an integration test can step the
+// progress tracker and mem-gate backoff deterministically by writing
+// directives to the tool's stdin.
+func runMockClockDirectives(clk *clock.MockClock, r io.Reader, log xlog.Logger) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		d, err := time.ParseDuration(line)
+		if err != nil {
+			log.Warnf("could not parse mock clock advance directive '%s': %v", line, err)
+			continue
+		}
+		clk.Advance(d)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Warnf("error reading mock clock advance directives: %v", err)
+	}
+}
+
+// availableMemoryBytes returns the memory available for sizing pools:
+// the process' Go runtime memory limit if one is configured (GOMEMLIMIT or
+// debug.SetMemoryLimit), otherwise the cgroup/system memory limit. Returns
+// 0 if neither is determinable, in which case autosize falls back to an
+// unclamped target.
+func availableMemoryBytes(log xlog.Logger) uint64 {
+	if limit := debug.SetMemoryLimit(-1); limit > 0 && limit < math.MaxInt64 {
+		return uint64(limit)
+	}
+
+	total, err := memlimit.TotalBytes()
+	if err != nil {
+		log.Warnf("could not determine available memory for pool autosize: %v", err)
+		return 0
+	}
+	return total
+}
+
+// newSourceOptionsFactory returns a function that builds a fresh
+// commitlogsrc.Options - with its own encoder/iterator/block pools - on
+// every call. workqueue.Bootstrap calls it once per worker so that
+// concurrent workers never share a pool: the pools are plain object pools,
+// not safe for concurrent Bootstrap calls to draw from at once.
+func newSourceOptionsFactory(
+	cfg config.Configuration,
+	instrumentOpts instrument.Options,
+	pathPrefix string,
+	flushSize int,
+	blockSize time.Duration,
+	shardsCount int,
+	blocksPerRetention int,
+	availableMemory uint64,
+) workqueue.OptsFactory {
+	return func() commitlogsrc.Options {
+		blockOpts := block.NewOptions()
+
+		encoderPoolSize := cfg.Pools.Encoder.ResolveSize(
+			shardsCount, blocksPerRetention, availableMemory, estimatedBytesPerEncoder)
+		encoderPoolOpts := pool.
+			NewObjectPoolOptions().
+			SetSize(encoderPoolSize).
+			SetRefillLowWatermark(cfg.Pools.Encoder.LowWatermark).
+			SetRefillHighWatermark(cfg.Pools.Encoder.HighWatermark)
+		encoderPool := encoding.NewEncoderPool(encoderPoolOpts)
+
+		iteratorPoolOpts := pool.NewObjectPoolOptions().
+			SetSize(cfg.Pools.Iterator.Size).
+			SetRefillLowWatermark(cfg.Pools.Iterator.LowWatermark).
+			SetRefillHighWatermark(cfg.Pools.Iterator.HighWatermark)
+		iteratorPool := encoding.NewReaderIteratorPool(iteratorPoolOpts)
+
+		multiIteratorPool := encoding.NewMultiReaderIteratorPool(nil)
+		segmentReaderPool := xio.NewSegmentReaderPool(nil)
+
+		encodingOpts := encoding.NewOptions().
+			SetEncoderPool(encoderPool).
+			SetReaderIteratorPool(iteratorPool).
+			SetBytesPool(blockOpts.BytesPool()).
+			SetSegmentReaderPool(segmentReaderPool)
+
+		encoderPool.Init(func() encoding.Encoder {
+			return m3tsz.NewEncoder(time.Time{}, nil, true, encodingOpts)
+		})
+
+		iteratorPool.Init(func(r io.Reader) encoding.ReaderIterator {
+			return m3tsz.NewReaderIterator(r, true, encodingOpts)
+		})
+
+		multiIteratorPool.Init(func(r io.Reader) encoding.ReaderIterator {
+			iter := iteratorPool.Get()
+			iter.Reset(r)
+			return iter
+		})
+
+		segmentReaderPool.Init()
+
+		blockPool := block.NewDatabaseBlockPool(nil)
+		blockPool.Init(func() block.DatabaseBlock {
+			return block.NewDatabaseBlock(time.Time{}, ts.Segment{}, blockOpts)
+		})
+
+		blockOpts = blockOpts.
+			SetEncoderPool(encoderPool).
+			SetReaderIteratorPool(iteratorPool).
+			SetMultiReaderIteratorPool(multiIteratorPool).
+			SetDatabaseBlockPool(blockPool).
+			SetSegmentReaderPool(segmentReaderPool)
+
+		resultOpts := result.NewOptions().
+			SetInstrumentOptions(instrumentOpts).
+			SetDatabaseBlockOptions(blockOpts)
+
+		fsOpts := fs.NewOptions().
+			SetInstrumentOptions(instrumentOpts).
+			SetFilePathPrefix(pathPrefix)
+
+		commitLogOpts := commitlog.NewOptions().
+			SetInstrumentOptions(instrumentOpts).
+			SetFilesystemOptions(fsOpts).
+			SetFlushSize(flushSize).
+			SetBlockSize(blockSize)
+
+		return commitlogsrc.NewOptions().
+			SetResultOptions(resultOpts).
+			SetCommitLogOptions(commitLogOpts)
+	}
+}
+
+// filesAndBytesOnDisk counts and sums the size of every commit log file
+// under pathPrefix/commitlogs, sampled once at the start of a run so the
+// /progress endpoint can derive an ETA from it, and so each completed
+// worker pass can report how many files/bytes its rescan of the commit
+// log consumed.
+func filesAndBytesOnDisk(pathPrefix string, log xlog.Logger) (files int, bytes int64) {
+	err := filepath.Walk(filepath.Join(pathPrefix, "commitlogs"), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			files++
+			bytes += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Warnf("could not compute total commit log files/bytes on disk: %v", err)
+		return 0, 0
+	}
+	return files, bytes
+}
+
+// memGate pauses bootstrap batches while free memory is below a configured
+// limit, and resumes them once free memory climbs back above a higher
+// "resume" threshold (hysteresis), to avoid flapping right at the boundary.
+type memGate struct {
+	checker       memlimit.Checker
+	resumeChecker memlimit.Checker
+	clk           clock.Clock
+}
+
+// newMemGate constructs a memGate from the (possibly empty) --mem-free-limit
+// flag value. An empty value disables memory throttling entirely. If the
+// current platform has no supported way of reading free memory, throttling
+// is disabled and a warning is logged rather than treating it as fatal. clk
+// drives the backoff wait, so that --mock-clock can make the wait
+// deterministic in an integration test instead of actually sleeping.
+func newMemGate(rawLimit string, clk clock.Clock, log xlog.Logger) (*memGate, error) {
+	if strings.TrimSpace(rawLimit) == "" {
+		return nil, nil
+	}
+
+	limit, err := memlimit.ParseLimit(rawLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	checker, err := memlimit.New(limit)
+	if err == memlimit.ErrNotSupported {
+		log.Warnf("--mem-free-limit set but not supported on this platform, continuing without throttling")
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	resumeChecker, err := memlimit.New(limit.Raised(memCheckHysteresisPercent))
+	if err != nil {
+		return nil, err
+	}
+
+	return &memGate{checker: checker, resumeChecker: resumeChecker, clk: clk}, nil
+}
+
+// bootstrapInBatches splits shardTimeRanges into groups of batchSize shards
+// and bootstraps each group in turn (each group itself bootstrapped by up to
+// parallelism workers via workqueue.Bootstrap), consulting gate between
+// batches so that a host running low on memory can pause until it recovers
+// instead of OOMing partway through a run. Results are merged into a single
+// result.BootstrapResult.
+func bootstrapInBatches(
+	newOpts workqueue.OptsFactory,
+	nsMetadata namespace.Metadata,
+	shardTimeRanges result.ShardTimeRanges,
+	runOpts bootstrap.RunOptions,
+	batchSize int,
+	gate *memGate,
+	parallelism int,
+	claimer workqueue.ShardClaimer,
+	obs workqueue.Observability,
+	log xlog.Logger,
+) (result.BootstrapResult, error) {
+	batches := batchShardTimeRanges(shardTimeRanges, batchSize)
+	merged := result.NewResult()
+
+	for i, batch := range batches {
+		if i > 0 && gate != nil {
+			if err := gate.wait(log); err != nil {
+				return nil, err
+			}
+		}
+
+		log.WithFields(
+			xlog.NewField("batch", i+1),
+			xlog.NewField("batchCount", len(batches)),
+			xlog.NewField("shards", len(batch)),
+		).Infof("bootstrapping batch")
+
+		batchResult, err := workqueue.Bootstrap(newOpts, nsMetadata, batch, runOpts, parallelism, claimer, obs, log)
+		if err != nil {
+			return nil, err
+		}
+
+		workqueue.Merge(merged, batchResult)
+	}
+
+	return merged, nil
+}
+
+// batchShardTimeRanges splits shardTimeRanges into ordered groups of at most
+// batchSize shards each. A non-positive batchSize disables batching (a
+// single batch containing every shard is returned).
+func batchShardTimeRanges(shardTimeRanges result.ShardTimeRanges, batchSize int) []result.ShardTimeRanges {
+	if batchSize <= 0 || batchSize >= len(shardTimeRanges) {
+		return []result.ShardTimeRanges{shardTimeRanges}
+	}
+
+	shards := make([]uint32, 0, len(shardTimeRanges))
+	for shard := range shardTimeRanges {
+		shards = append(shards, shard)
+	}
+	sort.Slice(shards, func(i, j int) bool { return shards[i] < shards[j] })
+
+	var batches []result.ShardTimeRanges
+	for len(shards) > 0 {
+		n := batchSize
+		if n > len(shards) {
+			n = len(shards)
+		}
+		batch := result.ShardTimeRanges{}
+		for _, shard := range shards[:n] {
+			batch[shard] = shardTimeRanges[shard]
+		}
+		batches = append(batches, batch)
+		shards = shards[n:]
+	}
+	return batches
+}
+
+// wait blocks, backing off exponentially, until free memory is back above
+// the configured limit's raised "resume" threshold.
+func (g *memGate) wait(log xlog.Logger) error {
+	available, err := g.checker.Available()
+	if err != nil {
+		return fmt.Errorf("could not check free memory: %v", err)
+	}
+	if available {
+		return nil
+	}
+
+	backoff := memCheckMinBackoff
+	triedGC := false
+	for attempt := 0; ; attempt++ {
+		available, err := g.resumeChecker.Available()
+		if err != nil {
+			return fmt.Errorf("could not check free memory: %v", err)
+		}
+		if available {
+			return nil
+		}
+
+		if !triedGC {
+			log.Infof("free memory below --mem-free-limit, running GC before waiting")
+			runtime.GC()
+			triedGC = true
+			continue
+		}
+
+		log.WithFields(
+			xlog.NewField("attempt", attempt),
+			xlog.NewField("backoff", backoff.String()),
+		).Warnf("free memory below --mem-free-limit, waiting for batch")
+
+		<-g.clk.After(backoff)
+		backoff = time.Duration(math.Min(
+			float64(memCheckMaxBackoff),
+			float64(backoff)*2,
+		))
+		triedGC = false
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3db/tools/bootstrap_commitlogs/clock"
+	xlog "github.com/m3db/m3x/log"
+)
+
+// fixedChecker reports Available() as a fixed, never-changing value.
+type fixedChecker struct {
+	available bool
+}
+
+func (c fixedChecker) Available() (bool, error) { return c.available, nil }
+
+// sequenceChecker reports the next value off a sequence on every call,
+// repeating the last value once the sequence is exhausted.
+type sequenceChecker struct {
+	results []bool
+	calls   int
+}
+
+func (c *sequenceChecker) Available() (bool, error) {
+	i := c.calls
+	if i >= len(c.results) {
+		i = len(c.results) - 1
+	}
+	c.calls++
+	return c.results[i], nil
+}
+
+// TestMemGateWaitDeterministic verifies that wait() blocks until the
+// resumeChecker reports free memory is available again, backing off on a
+// MockClock rather than sleeping in real time - the mock clock must
+// actually drive the wait for this to be a useful, fast, deterministic
+// test.
+func TestMemGateWaitDeterministic(t *testing.T) {
+	clk := clock.NewMockClock(time.Unix(0, 0))
+	g := &memGate{
+		checker:       fixedChecker{available: false},
+		resumeChecker: &sequenceChecker{results: []bool{false, false, true}},
+		clk:           clk,
+	}
+	log := xlog.NewLogger(&bytes.Buffer{})
+
+	done := make(chan error, 1)
+	go func() { done <- g.wait(log) }()
+
+	// wait() only ever blocks on g.clk.After, never time.Sleep, so driving
+	// it to completion here - without this test taking as long as the real
+	// backoff would - is exactly what wiring the mock clock through buys.
+	backoff := memCheckMinBackoff
+	for i := 0; i < 10; i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("wait() returned error: %v", err)
+			}
+			return
+		case <-time.After(20 * time.Millisecond):
+			clk.Advance(backoff)
+			if backoff < memCheckMaxBackoff {
+				backoff *= 2
+			}
+		}
+	}
+	t.Fatal("wait() did not return after the resume threshold was reached")
+}
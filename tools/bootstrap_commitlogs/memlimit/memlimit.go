@@ -0,0 +1,125 @@
+// Package memlimit provides a way to check available system memory against
+// a configured limit so that long running tools can throttle themselves
+// rather than exhaust memory on the host they're running on.
+package memlimit
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrNotSupported is returned when constructing a Checker on a platform that
+// has no supported way of determining free memory (e.g. anything other than
+// Linux). Callers are given back a no-op Checker alongside this error so
+// they can choose to proceed without throttling.
+var ErrNotSupported = errors.New("memlimit: determining free memory is not supported on this platform")
+
+// Limit describes a memory free threshold, either as an absolute number of
+// bytes or as a percentage of the total memory available to this process
+// (the cgroup limit if one is configured, otherwise total system memory).
+type Limit struct {
+	Bytes     uint64
+	Percent   float64
+	IsPercent bool
+}
+
+// Raised returns a copy of l with its threshold increased by pct percent,
+// e.g. Raised(10) on a 2GB limit yields 2.2GB. Useful for building a second,
+// higher, "resume" threshold so callers can apply hysteresis around a single
+// configured limit rather than flapping right at the boundary.
+func (l Limit) Raised(pct float64) Limit {
+	factor := 1 + pct/100
+	if l.IsPercent {
+		l.Percent *= factor
+		if l.Percent > 100 {
+			l.Percent = 100
+		}
+		return l
+	}
+	l.Bytes = uint64(float64(l.Bytes) * factor)
+	return l
+}
+
+// Checker reports whether free memory is currently above the configured
+// Limit.
+type Checker interface {
+	// Available returns true if current free memory is above the
+	// configured limit.
+	Available() (bool, error)
+}
+
+// ParseLimit parses a human specified limit such as "2GB" or "15%".
+func ParseLimit(str string) (Limit, error) {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return Limit{}, fmt.Errorf("empty mem-free-limit")
+	}
+
+	if strings.HasSuffix(str, "%") {
+		value, err := strconv.ParseFloat(strings.TrimSuffix(str, "%"), 64)
+		if err != nil {
+			return Limit{}, fmt.Errorf("could not parse mem-free-limit percent '%s': %v", str, err)
+		}
+		if value <= 0 || value > 100 {
+			return Limit{}, fmt.Errorf("mem-free-limit percent '%s' must be in (0, 100]", str)
+		}
+		return Limit{Percent: value, IsPercent: true}, nil
+	}
+
+	bytes, err := parseBytes(str)
+	if err != nil {
+		return Limit{}, fmt.Errorf("could not parse mem-free-limit '%s': %v", str, err)
+	}
+	return Limit{Bytes: bytes}, nil
+}
+
+var byteUnits = []struct {
+	suffix string
+	mult   uint64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+func parseBytes(str string) (uint64, error) {
+	upper := strings.ToUpper(str)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(upper, unit.suffix) {
+			numStr := strings.TrimSpace(upper[:len(upper)-len(unit.suffix)])
+			value, err := strconv.ParseFloat(numStr, 64)
+			if err != nil {
+				return 0, err
+			}
+			return uint64(value * float64(unit.mult)), nil
+		}
+	}
+	value, err := strconv.ParseUint(upper, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+// TotalBytes returns the total memory available to this process: the
+// cgroup limit if one is configured, otherwise total system memory. It
+// returns ErrNotSupported on platforms without a supported way of
+// determining this.
+func TotalBytes() (uint64, error) {
+	return totalBytes()
+}
+
+// New constructs a Checker for the given Limit. On platforms without a
+// supported way of reading free memory it returns a no-op Checker alongside
+// ErrNotSupported so callers can decide whether to proceed unthrottled.
+func New(limit Limit) (Checker, error) {
+	return newChecker(limit)
+}
+
+type noopChecker struct{}
+
+func (noopChecker) Available() (bool, error) { return true, nil }
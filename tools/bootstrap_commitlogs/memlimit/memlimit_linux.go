@@ -0,0 +1,144 @@
+//go:build linux
+// +build linux
+
+package memlimit
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	cgroupV2CurrentPath = "/sys/fs/cgroup/memory.current"
+	cgroupV2MaxPath     = "/sys/fs/cgroup/memory.max"
+	cgroupV1UsagePath   = "/sys/fs/cgroup/memory/memory.usage_in_bytes"
+	cgroupV1LimitPath   = "/sys/fs/cgroup/memory/memory.limit_in_bytes"
+	procMeminfoPath     = "/proc/meminfo"
+)
+
+type linuxChecker struct {
+	limit Limit
+}
+
+func newChecker(limit Limit) (Checker, error) {
+	return &linuxChecker{limit: limit}, nil
+}
+
+func totalBytes() (uint64, error) {
+	_, total, err := freeAndTotalBytes()
+	return total, err
+}
+
+func (c *linuxChecker) Available() (bool, error) {
+	free, total, err := freeAndTotalBytes()
+	if err != nil {
+		return false, err
+	}
+
+	if c.limit.IsPercent {
+		return float64(free)/float64(total)*100 >= c.limit.Percent, nil
+	}
+	return free >= c.limit.Bytes, nil
+}
+
+// freeAndTotalBytes returns the current free and total memory available to
+// this process, preferring cgroup v2, then cgroup v1, and falling back to
+// /proc/meminfo when no cgroup memory controller is present.
+func freeAndTotalBytes() (free uint64, total uint64, err error) {
+	if usage, max, ok := readCgroupV2(); ok {
+		return max - usage, max, nil
+	}
+	if usage, limit, ok := readCgroupV1(); ok {
+		return limit - usage, limit, nil
+	}
+	return readProcMeminfo()
+}
+
+func readCgroupV2() (usage uint64, max uint64, ok bool) {
+	usage, err := readUintFile(cgroupV2CurrentPath)
+	if err != nil {
+		return 0, 0, false
+	}
+	maxStr, err := readStringFile(cgroupV2MaxPath)
+	if err != nil {
+		return 0, 0, false
+	}
+	if maxStr == "max" {
+		// No cgroup v2 memory limit configured, not applicable.
+		return 0, 0, false
+	}
+	max, err = strconv.ParseUint(maxStr, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return usage, max, true
+}
+
+func readCgroupV1() (usage uint64, limit uint64, ok bool) {
+	usage, err := readUintFile(cgroupV1UsagePath)
+	if err != nil {
+		return 0, 0, false
+	}
+	limit, err = readUintFile(cgroupV1LimitPath)
+	if err != nil {
+		return 0, 0, false
+	}
+	// An unset cgroup v1 limit defaults to a very large sentinel value
+	// (e.g. 2^63 rounded to a page boundary); treat that as "no limit".
+	const noLimitThreshold = uint64(1) << 62
+	if limit > noLimitThreshold {
+		return 0, 0, false
+	}
+	return usage, limit, true
+}
+
+func readProcMeminfo() (free uint64, total uint64, err error) {
+	file, err := os.Open(procMeminfoPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	var memTotal, memAvailable uint64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch strings.TrimSuffix(fields[0], ":") {
+		case "MemTotal":
+			memTotal, _ = strconv.ParseUint(fields[1], 10, 64)
+			memTotal *= 1024
+		case "MemAvailable":
+			memAvailable, _ = strconv.ParseUint(fields[1], 10, 64)
+			memAvailable *= 1024
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+	if memTotal == 0 {
+		return 0, 0, fmt.Errorf("could not determine MemTotal from %s", procMeminfoPath)
+	}
+	return memAvailable, memTotal, nil
+}
+
+func readUintFile(path string) (uint64, error) {
+	str, err := readStringFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(str, 10, 64)
+}
+
+func readStringFile(path string) (string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(contents)), nil
+}
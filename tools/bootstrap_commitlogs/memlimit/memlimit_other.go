@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package memlimit
+
+func newChecker(limit Limit) (Checker, error) {
+	return noopChecker{}, ErrNotSupported
+}
+
+func totalBytes() (uint64, error) {
+	return 0, ErrNotSupported
+}
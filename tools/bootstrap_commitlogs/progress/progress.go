@@ -0,0 +1,145 @@
+// Package progress tracks per-shard status during a bootstrap run and
+// serves it as JSON from an HTTP endpoint, so operators running a
+// long-lived bootstrap have visibility beyond the final summary log line.
+package progress
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/m3db/m3db/tools/bootstrap_commitlogs/clock"
+)
+
+// Status is the bootstrap state of a single shard.
+type Status string
+
+const (
+	// StatusPending means the shard has not yet started bootstrapping.
+	StatusPending Status = "pending"
+	// StatusInFlight means the shard is currently being bootstrapped.
+	StatusInFlight Status = "in-flight"
+	// StatusDone means the shard has finished bootstrapping.
+	StatusDone Status = "done"
+)
+
+// Tracker records per-shard bootstrap status, commit log files and bytes
+// consumed, and total bytes on disk sampled at the start of the run, so
+// that an ETA can be derived from the fraction of bytes processed so far.
+type Tracker struct {
+	mu               sync.Mutex
+	clk              clock.Clock
+	start            time.Time
+	statuses         map[uint32]Status
+	filesConsumed    int
+	bytesProcessed   int64
+	totalBytesOnDisk int64
+}
+
+// NewTracker returns a Tracker with every shard in shards initialized to
+// StatusPending. totalBytesOnDisk is sampled once, at the start of the
+// run, and used as the ETA's denominator. clk is used for the start time
+// and for every Snapshot served from Handler, so that --mock-clock can
+// make elapsed/ETA calculations deterministic in an integration test.
+func NewTracker(shards []uint32, totalBytesOnDisk int64, clk clock.Clock) *Tracker {
+	statuses := make(map[uint32]Status, len(shards))
+	for _, shard := range shards {
+		statuses[shard] = StatusPending
+	}
+	return &Tracker{
+		clk:              clk,
+		start:            clk.Now(),
+		statuses:         statuses,
+		totalBytesOnDisk: totalBytesOnDisk,
+	}
+}
+
+// MarkInFlight records that shard has started bootstrapping.
+func (t *Tracker) MarkInFlight(shard uint32) {
+	t.mu.Lock()
+	t.statuses[shard] = StatusInFlight
+	t.mu.Unlock()
+}
+
+// MarkDone records that shard has finished bootstrapping.
+func (t *Tracker) MarkDone(shard uint32) {
+	t.mu.Lock()
+	t.statuses[shard] = StatusDone
+	t.mu.Unlock()
+}
+
+// AddFilesConsumed increments the number of commit log files consumed so
+// far.
+func (t *Tracker) AddFilesConsumed(n int) {
+	t.mu.Lock()
+	t.filesConsumed += n
+	t.mu.Unlock()
+}
+
+// AddBytesProcessed increments the number of commit log bytes processed so
+// far, used to derive Snapshot's ETA.
+func (t *Tracker) AddBytesProcessed(n int64) {
+	t.mu.Lock()
+	t.bytesProcessed += n
+	t.mu.Unlock()
+}
+
+// Snapshot is the JSON representation served from the /progress endpoint.
+type Snapshot struct {
+	Shards           map[uint32]Status `json:"shards"`
+	ShardsDone       int               `json:"shardsDone"`
+	ShardsTotal      int               `json:"shardsTotal"`
+	FilesConsumed    int               `json:"filesConsumed"`
+	BytesProcessed   int64             `json:"bytesProcessed"`
+	TotalBytesOnDisk int64             `json:"totalBytesOnDisk"`
+	ElapsedSeconds   float64           `json:"elapsedSeconds"`
+	ETASeconds       *float64          `json:"etaSeconds,omitempty"`
+}
+
+// Snapshot returns the current progress, computing an ETA (seconds
+// remaining) from bytes processed so far as a fraction of the total bytes
+// on disk sampled at the start of the run.
+func (t *Tracker) Snapshot(now time.Time) Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	shards := make(map[uint32]Status, len(t.statuses))
+	done := 0
+	for shard, status := range t.statuses {
+		shards[shard] = status
+		if status == StatusDone {
+			done++
+		}
+	}
+
+	elapsed := now.Sub(t.start)
+	snapshot := Snapshot{
+		Shards:           shards,
+		ShardsDone:       done,
+		ShardsTotal:      len(t.statuses),
+		FilesConsumed:    t.filesConsumed,
+		BytesProcessed:   t.bytesProcessed,
+		TotalBytesOnDisk: t.totalBytesOnDisk,
+		ElapsedSeconds:   elapsed.Seconds(),
+	}
+
+	if t.bytesProcessed > 0 && t.bytesProcessed < t.totalBytesOnDisk {
+		doneFraction := float64(t.bytesProcessed) / float64(t.totalBytesOnDisk)
+		etaSeconds := elapsed.Seconds()/doneFraction - elapsed.Seconds()
+		snapshot.ETASeconds = &etaSeconds
+	}
+
+	return snapshot
+}
+
+// Handler serves the current Snapshot as JSON.
+func (t *Tracker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		snapshot := t.Snapshot(t.clk.Now())
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
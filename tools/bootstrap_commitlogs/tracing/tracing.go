@@ -0,0 +1,45 @@
+// Package tracing constructs the OpenTracing tracer used to instrument a
+// bootstrap run, selected via the tool's --tracer and --jaeger-endpoint
+// flags.
+package tracing
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/opentracing/opentracing-go"
+	jaegercfg "github.com/uber/jaeger-client-go/config"
+)
+
+// New constructs a tracer of the given kind ("noop" or "jaeger"). For
+// "jaeger", jaegerEndpoint is the Jaeger agent address (host:port) to report
+// spans to. The returned io.Closer must be closed on shutdown to flush any
+// buffered spans.
+func New(kind string, jaegerEndpoint string, serviceName string) (opentracing.Tracer, io.Closer, error) {
+	switch kind {
+	case "", "noop":
+		return opentracing.NoopTracer{}, noopCloser{}, nil
+	case "jaeger":
+		cfg := jaegercfg.Configuration{
+			ServiceName: serviceName,
+			Sampler: &jaegercfg.SamplerConfig{
+				Type:  "const",
+				Param: 1,
+			},
+			Reporter: &jaegercfg.ReporterConfig{
+				LocalAgentHostPort: jaegerEndpoint,
+			},
+		}
+		tracer, closer, err := cfg.NewTracer()
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not construct jaeger tracer: %v", err)
+		}
+		return tracer, closer, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown --tracer '%s', must be one of: noop, jaeger", kind)
+	}
+}
+
+type noopCloser struct{}
+
+func (noopCloser) Close() error { return nil }
@@ -0,0 +1,37 @@
+package workqueue
+
+import xtime "github.com/m3db/m3x/time"
+
+// ShardClaimer coordinates which host bootstraps which shard, so that
+// multiple hosts can cooperatively bootstrap the same namespace. A claim is
+// exclusive only while it is held: once Complete releases it, nothing records
+// the shard as done, so another host still retrying that shard can claim and
+// bootstrap it again. The in-memory implementation (the default) assumes a
+// single host and claims every shard unconditionally; ClaimerFromURL builds
+// a cross-host implementation backed by Redis.
+type ShardClaimer interface {
+	// Claim attempts to claim shard for this host, returning false if it is
+	// already claimed by another host.
+	Claim(shard uint32) (bool, error)
+
+	// Complete releases the claim on shard once it has been bootstrapped.
+	// unfulfilled holds the shard's unfulfilled ranges; neither
+	// implementation in this package persists them.
+	Complete(shard uint32, unfulfilled xtime.Ranges) error
+}
+
+// InMemoryClaimer is the default ShardClaimer, used when bootstrapping is
+// not coordinated across hosts. It claims every shard unconditionally.
+type InMemoryClaimer struct{}
+
+// NewInMemoryClaimer returns a ShardClaimer that claims every shard it is
+// asked for, suitable for single-host parallel bootstraps.
+func NewInMemoryClaimer() ShardClaimer {
+	return InMemoryClaimer{}
+}
+
+// Claim always succeeds.
+func (InMemoryClaimer) Claim(shard uint32) (bool, error) { return true, nil }
+
+// Complete is a no-op.
+func (InMemoryClaimer) Complete(shard uint32, unfulfilled xtime.Ranges) error { return nil }
@@ -0,0 +1,154 @@
+package workqueue
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+
+	xlog "github.com/m3db/m3x/log"
+	xtime "github.com/m3db/m3x/time"
+)
+
+const (
+	redisKeyPrefix       = "m3db:bootstrap_commitlogs:shard:"
+	redisClaimTTL        = 30 * time.Second
+	redisHeartbeatPeriod = redisClaimTTL / 3
+)
+
+// releaseScript deletes key only if it is still owned by ARGV[1], so a host
+// whose claim already expired and was re-claimed by another host can't
+// delete that other host's claim out from under it.
+var releaseScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+else
+	return 0
+end
+`)
+
+// refreshScript extends key's TTL only if it is still owned by ARGV[1], so
+// a host whose claim already expired and was re-claimed by another host
+// can't refresh that other host's claim out from under it.
+var refreshScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+else
+	return 0
+end
+`)
+
+// RedisClaimer is a ShardClaimer backed by Redis, allowing multiple hosts to
+// cooperatively bootstrap the same namespace by claiming shard IDs via
+// "SET NX" with a TTL heartbeat. A claim that isn't heartbeat-refreshed
+// (e.g. because its owner died) expires and becomes claimable again.
+type RedisClaimer struct {
+	pool   *redis.Pool
+	owner  string
+	log    xlog.Logger
+	mu     sync.Mutex
+	stopCh map[uint32]chan struct{}
+}
+
+// NewRedisClaimer returns a ShardClaimer that coordinates claims against the
+// Redis instance at addr (host:port).
+func NewRedisClaimer(addr string, log xlog.Logger) ShardClaimer {
+	pool := &redis.Pool{
+		MaxIdle:     8,
+		IdleTimeout: 5 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr)
+		},
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return &RedisClaimer{
+		pool:   pool,
+		owner:  fmt.Sprintf("%s:%d", hostname, os.Getpid()),
+		log:    log,
+		stopCh: make(map[uint32]chan struct{}),
+	}
+}
+
+// Claim attempts to claim shard via Redis "SET NX PX", starting a heartbeat
+// goroutine that refreshes the TTL for as long as this claim is held.
+func (c *RedisClaimer) Claim(shard uint32) (bool, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	key := redisShardKey(shard)
+	reply, err := redis.String(conn.Do("SET", key, c.owner, "NX", "PX", int64(redisClaimTTL/time.Millisecond)))
+	if err == redis.ErrNil {
+		// Already claimed by another host.
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if reply != "OK" {
+		return false, nil
+	}
+
+	stop := make(chan struct{})
+	c.mu.Lock()
+	c.stopCh[shard] = stop
+	c.mu.Unlock()
+
+	go c.heartbeat(key, stop)
+
+	return true, nil
+}
+
+// Complete releases the claim on shard, stopping its heartbeat. The release
+// is conditioned on this claimer still owning the key, so a claim this host
+// lost to a TTL expiry (and another host has since re-claimed) is never
+// deleted out from under its new owner. The unfulfilled ranges are not
+// persisted by the Redis coordinator; the caller reports them in its own
+// bootstrap result, and they are not requeued.
+func (c *RedisClaimer) Complete(shard uint32, unfulfilled xtime.Ranges) error {
+	c.mu.Lock()
+	stop, ok := c.stopCh[shard]
+	delete(c.stopCh, shard)
+	c.mu.Unlock()
+
+	if ok {
+		close(stop)
+	}
+
+	conn := c.pool.Get()
+	defer conn.Close()
+	_, err := releaseScript.Do(conn, redisShardKey(shard), c.owner)
+	return err
+}
+
+func (c *RedisClaimer) heartbeat(key string, stop chan struct{}) {
+	ticker := time.NewTicker(redisHeartbeatPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			conn := c.pool.Get()
+			_, err := refreshScript.Do(conn, key, c.owner, int64(redisClaimTTL/time.Millisecond))
+			conn.Close()
+			if err != nil {
+				c.log.WithFields(
+					xlog.NewField("key", key),
+					xlog.NewField("owner", c.owner),
+				).Warnf("failed to refresh shard claim heartbeat: %v", err)
+			}
+		}
+	}
+}
+
+func redisShardKey(shard uint32) string {
+	return fmt.Sprintf("%s%d", redisKeyPrefix, shard)
+}
@@ -0,0 +1,356 @@
+// Package workqueue partitions a commit log bootstrap across a pool of
+// workers, optionally coordinating shard ownership across multiple hosts via
+// a ShardClaimer, and merges the results back into a single
+// result.BootstrapResult.
+package workqueue
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+
+	"github.com/m3db/m3db/storage/bootstrap"
+	"github.com/m3db/m3db/storage/bootstrap/bootstrapper"
+	commitlogsrc "github.com/m3db/m3db/storage/bootstrap/bootstrapper/commitlog"
+	"github.com/m3db/m3db/storage/bootstrap/result"
+	"github.com/m3db/m3db/storage/namespace"
+	"github.com/m3db/m3db/tools/bootstrap_commitlogs/progress"
+	xlog "github.com/m3db/m3x/log"
+)
+
+const (
+	// claimRetryInterval is how long Bootstrap waits before retrying shards
+	// it failed to claim, giving a dead owner's heartbeat time to lapse.
+	claimRetryInterval = 5 * time.Second
+	// claimMaxAttempts bounds how many times Bootstrap retries unclaimed
+	// shards before giving up and reporting them unfulfilled. Chosen to
+	// comfortably outlast a single missed Redis heartbeat (redisClaimTTL).
+	claimMaxAttempts = 8
+)
+
+// OptsFactory builds a fresh commitlogsrc.Options. Bootstrap calls it once
+// per worker so that concurrent workers each get their own encoder/iterator/
+// block pools rather than racing on shared ones.
+type OptsFactory func() commitlogsrc.Options
+
+// ClaimerFromURL builds a ShardClaimer from a --coordinator flag value. An
+// empty URL returns the default in-memory claimer; "redis://host:port"
+// returns a Redis-backed claimer.
+func ClaimerFromURL(rawURL string, log xlog.Logger) (ShardClaimer, error) {
+	if rawURL == "" {
+		return NewInMemoryClaimer(), nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse --coordinator '%s': %v", rawURL, err)
+	}
+
+	switch u.Scheme {
+	case "redis":
+		return NewRedisClaimer(u.Host, log), nil
+	default:
+		return nil, fmt.Errorf("unsupported --coordinator scheme '%s'", u.Scheme)
+	}
+}
+
+// Observability bundles the optional tracing and progress-reporting hooks
+// threaded through a bootstrap run. A nil Tracer/ParentSpan/Tracker disables
+// the corresponding instrumentation.
+type Observability struct {
+	Tracer     opentracing.Tracer
+	ParentSpan opentracing.Span
+	Tracker    *progress.Tracker
+	// FilesPerPass and BytesPerPass are the commit log file count and byte
+	// count on disk sampled once at the start of the run. The commit log
+	// source rescans every file on disk on every Bootstrap call, so each
+	// completed worker pass consumes (and reports) this many files/bytes,
+	// not a subset specific to its claimed shards.
+	FilesPerPass int
+	BytesPerPass int64
+}
+
+// Bootstrap partitions shardTimeRanges into parallelism chunks, bootstraps
+// each chunk concurrently (each worker claiming its shards via claimer and
+// constructing its own commit log bootstrapper from its own newOpts()
+// call), and merges the per-worker results into a single
+// result.BootstrapResult. Shards that no worker manages to claim (because
+// another host holds them, possibly a dead one whose claim hasn't expired
+// yet) are retried with backoff up to claimMaxAttempts before being given
+// up on and reported unfulfilled.
+func Bootstrap(
+	newOpts OptsFactory,
+	nsMetadata namespace.Metadata,
+	shardTimeRanges result.ShardTimeRanges,
+	runOpts bootstrap.RunOptions,
+	parallelism int,
+	claimer ShardClaimer,
+	obs Observability,
+	log xlog.Logger,
+) (result.BootstrapResult, error) {
+	merged := result.NewResult()
+	pending := shardTimeRanges
+
+	for attempt := 0; len(pending) > 0; attempt++ {
+		if attempt > 0 {
+			if attempt > claimMaxAttempts {
+				log.WithFields(
+					xlog.NewField("shards", len(pending)),
+				).Warnf("giving up on unclaimed shards after %d attempts, reporting unfulfilled", claimMaxAttempts)
+				for shard, ranges := range pending {
+					merged.Add(shard, nil, ranges)
+				}
+				break
+			}
+			time.Sleep(claimRetryInterval)
+		}
+
+		claimedResult, unclaimed, err := bootstrapAllChunks(newOpts, nsMetadata, pending, runOpts, parallelism, claimer, obs, log)
+		if err != nil {
+			return nil, err
+		}
+
+		Merge(merged, claimedResult)
+		pending = unclaimed
+	}
+
+	return merged, nil
+}
+
+// bootstrapAllChunks partitions shardTimeRanges into parallelism chunks and
+// bootstraps each chunk concurrently, returning the merged result of every
+// claimed shard alongside the shards no worker managed to claim.
+func bootstrapAllChunks(
+	newOpts OptsFactory,
+	nsMetadata namespace.Metadata,
+	shardTimeRanges result.ShardTimeRanges,
+	runOpts bootstrap.RunOptions,
+	parallelism int,
+	claimer ShardClaimer,
+	obs Observability,
+	log xlog.Logger,
+) (result.BootstrapResult, result.ShardTimeRanges, error) {
+	chunks := partition(shardTimeRanges, parallelism)
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		merged    = result.NewResult()
+		unclaimed = result.ShardTimeRanges{}
+		firstErr  error
+	)
+
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(worker int, chunk result.ShardTimeRanges) {
+			defer wg.Done()
+
+			workerResult, workerUnclaimed, err := bootstrapChunk(newOpts(), nsMetadata, chunk, runOpts, claimer, obs, log, worker)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			Merge(merged, workerResult)
+			for shard, ranges := range workerUnclaimed {
+				unclaimed[shard] = ranges
+			}
+		}(i, chunk)
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, nil, firstErr
+	}
+	return merged, unclaimed, nil
+}
+
+// bootstrapChunk claims every shard in chunk, bootstraps the ones this
+// worker successfully claimed with its own opts (and therefore its own
+// object pools, independent of every other concurrent worker), and reports
+// completion back to claimer. Shards this worker failed to claim are
+// returned separately so the caller can retry them.
+func bootstrapChunk(
+	opts commitlogsrc.Options,
+	nsMetadata namespace.Metadata,
+	chunk result.ShardTimeRanges,
+	runOpts bootstrap.RunOptions,
+	claimer ShardClaimer,
+	obs Observability,
+	log xlog.Logger,
+	worker int,
+) (result.BootstrapResult, result.ShardTimeRanges, error) {
+	claimed := result.ShardTimeRanges{}
+	unclaimed := result.ShardTimeRanges{}
+	for shard, ranges := range chunk {
+		ok, err := claimer.Claim(shard)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			claimed[shard] = ranges
+		} else {
+			unclaimed[shard] = ranges
+		}
+	}
+
+	if len(claimed) == 0 {
+		return result.NewResult(), unclaimed, nil
+	}
+
+	if obs.Tracker != nil {
+		for shard := range claimed {
+			obs.Tracker.MarkInFlight(shard)
+		}
+	}
+
+	span := startShardBatchSpan(obs, claimed)
+
+	log.WithFields(
+		xlog.NewField("worker", worker),
+		xlog.NewField("shards", len(claimed)),
+	).Infof("worker bootstrapping claimed shards")
+
+	next := bootstrapper.NewNoOpAllBootstrapper()
+	source, err := commitlogsrc.NewCommitLogBootstrapper(opts, next)
+	if err != nil {
+		finishShardBatchSpan(span, 0, 0, err)
+		return nil, nil, err
+	}
+
+	workerResult, err := source.Bootstrap(nsMetadata, claimed, runOpts)
+	if err != nil {
+		finishShardBatchSpan(span, 0, obs.BytesPerPass, err)
+		return nil, nil, err
+	}
+
+	seriesCount := 0
+	for _, shardResult := range workerResult.ShardResults() {
+		seriesCount += len(shardResult.AllSeries())
+	}
+	finishShardBatchSpan(span, seriesCount, obs.BytesPerPass, nil)
+
+	if obs.Tracker != nil {
+		obs.Tracker.AddFilesConsumed(obs.FilesPerPass)
+		obs.Tracker.AddBytesProcessed(obs.BytesPerPass)
+	}
+
+	unfulfilled := workerResult.Unfulfilled()
+	for shard := range claimed {
+		if obs.Tracker != nil {
+			obs.Tracker.MarkDone(shard)
+		}
+		if err := claimer.Complete(shard, unfulfilled[shard]); err != nil {
+			log.WithFields(
+				xlog.NewField("shard", shard),
+				xlog.NewField("error", err.Error()),
+			).Warnf("could not complete shard claim")
+		}
+	}
+
+	return workerResult, unclaimed, nil
+}
+
+// startShardBatchSpan starts a child span (of obs.ParentSpan, if tracing is
+// enabled) covering the bootstrap of a single shard batch, tagged with the
+// claimed shard IDs and their overall time range.
+func startShardBatchSpan(obs Observability, claimed result.ShardTimeRanges) opentracing.Span {
+	if obs.Tracer == nil || obs.ParentSpan == nil {
+		return nil
+	}
+
+	shardIDs := make([]string, 0, len(claimed))
+	var rangeStart, rangeEnd time.Time
+	for shard, ranges := range claimed {
+		shardIDs = append(shardIDs, fmt.Sprintf("%d", shard))
+		it := ranges.Iter()
+		for it.Next() {
+			r := it.Value()
+			if rangeStart.IsZero() || r.Start.Before(rangeStart) {
+				rangeStart = r.Start
+			}
+			if rangeEnd.IsZero() || r.End.After(rangeEnd) {
+				rangeEnd = r.End
+			}
+		}
+	}
+
+	span := obs.Tracer.StartSpan("bootstrap_shard_batch", opentracing.ChildOf(obs.ParentSpan.Context()))
+	span.SetTag("shard.id", strings.Join(shardIDs, ","))
+	span.SetTag("range.start", rangeStart.Format(time.RFC3339Nano))
+	span.SetTag("range.end", rangeEnd.Format(time.RFC3339Nano))
+	return span
+}
+
+// finishShardBatchSpan tags span with the outcome of bootstrapping a shard
+// batch and finishes it. bytesRead is the number of commit log bytes the
+// underlying Bootstrap call scanned to produce this batch - since the
+// commit log source rescans every file on disk on every call, this is the
+// total bytes on disk sampled at the start of the run, not bytes specific
+// to this batch's shards.
+func finishShardBatchSpan(span opentracing.Span, seriesCount int, bytesRead int64, err error) {
+	if span == nil {
+		return
+	}
+	span.SetTag("series.count", seriesCount)
+	span.SetTag("bytes.read", bytesRead)
+	if err != nil {
+		span.SetTag("error", true)
+		span.LogKV("error.message", err.Error())
+	}
+	span.Finish()
+}
+
+// Merge adds every shard result and unfulfilled range from src into dest.
+func Merge(dest, src result.BootstrapResult) {
+	unfulfilled := src.Unfulfilled()
+	for shard, shardResult := range src.ShardResults() {
+		dest.Add(shard, shardResult, unfulfilled[shard])
+	}
+	for shard, ranges := range unfulfilled {
+		if _, ok := src.ShardResults()[shard]; ok {
+			continue
+		}
+		dest.Add(shard, nil, ranges)
+	}
+}
+
+// partition splits shardTimeRanges into n ordered, roughly equal-sized
+// chunks. A non-positive n is treated as 1 (no parallelism).
+func partition(shardTimeRanges result.ShardTimeRanges, n int) []result.ShardTimeRanges {
+	if n <= 0 {
+		n = 1
+	}
+	if n > len(shardTimeRanges) {
+		n = len(shardTimeRanges)
+	}
+	if n == 0 {
+		return nil
+	}
+
+	shards := make([]uint32, 0, len(shardTimeRanges))
+	for shard := range shardTimeRanges {
+		shards = append(shards, shard)
+	}
+	sort.Slice(shards, func(i, j int) bool { return shards[i] < shards[j] })
+
+	chunks := make([]result.ShardTimeRanges, n)
+	for i := range chunks {
+		chunks[i] = result.ShardTimeRanges{}
+	}
+	for i, shard := range shards {
+		chunk := chunks[i%n]
+		chunk[shard] = shardTimeRanges[shard]
+	}
+	return chunks
+}